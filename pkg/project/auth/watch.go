@@ -0,0 +1,386 @@
+package auth
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/storage"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+
+	projectapi "github.com/openshift/openshift-apiserver/pkg/project/apis/project"
+	projectcache "github.com/openshift/openshift-apiserver/pkg/project/cache"
+)
+
+// notificationBufferSize is how many pending cache notifications we'll allow
+// to queue for a single watcher before we consider it too slow and forcibly
+// remove it rather than block the cache (and every other watcher) on it.
+const notificationBufferSize = 25
+
+// CacheWatcher is notified, while the project auth cache holds its lock, of
+// the full current set of users and groups with access to a namespace.
+// Implementations MUST NOT call back into the cache from this method.
+type CacheWatcher interface {
+	GroupMembershipChanged(namespaceName string, users, groups sets.String)
+}
+
+// WatchableCache is the subset of the project auth cache that a
+// userProjectWatcher needs: the ability to remove itself once it can no
+// longer keep up, and to list the namespaces a user currently has access to.
+type WatchableCache interface {
+	RemoveWatcher(watcher CacheWatcher)
+	List(userInfo user.Info, selector labels.Selector) (*corev1.NamespaceList, error)
+}
+
+// userProjectWatcher turns project auth cache notifications scoped to a
+// single user/group set into a watch.Interface of Project add/delete events.
+type userProjectWatcher struct {
+	user   user.Info
+	groups sets.String
+
+	predicate storage.SelectionPredicate
+
+	projectCache *projectcache.ProjectCache
+	cache        WatchableCache
+	recorder     record.EventRecorder
+
+	knownProjectsLock sync.Mutex
+	knownProjects     sets.String
+
+	// allowWatchBookmarks mirrors the AllowWatchBookmarks field of the
+	// ListOptions the caller used to start this watch.
+	allowWatchBookmarks bool
+	// bookmarkInterval, if non-zero, is how often a watch.Bookmark event
+	// carrying the latest observed resourceVersion is emitted. Zero disables
+	// bookmarks even if allowWatchBookmarks is true.
+	bookmarkInterval time.Duration
+	// resyncPeriod, if non-zero, is how often this watcher re-lists via the
+	// injected cache and reconciles its known projects against the result.
+	// Zero disables periodic resync.
+	resyncPeriod time.Duration
+
+	rvLock              sync.Mutex
+	lastResourceVersion uint64
+
+	// cacheIncoming receives notifications from the cache via emit.
+	cacheIncoming chan watch.Event
+	// cacheError receives a single error if this watcher is ever forcibly
+	// removed for being too slow to keep up with cacheIncoming.
+	cacheError chan error
+
+	// outgoing is returned from ResultChan(); it is unbuffered so a caller
+	// that isn't reading yet applies natural backpressure onto Watch().
+	outgoing chan watch.Event
+
+	stopChannel chan struct{}
+	stopOnce    sync.Once
+
+	// removeOnce ensures a slow watcher is only removed from the cache, and
+	// only records one WatchChannelFull event, the first time its
+	// cacheIncoming buffer is found full -- not once per queued emit that
+	// happens to land after the watcher is already gone.
+	removeOnce sync.Once
+
+	// emit is overridable for tests; defaults to defaultEmit.
+	emit func(watch.Event)
+}
+
+// NewUserProjectWatcher creates a watcher scoped to the given user and
+// groups. If includeAllExistingProjects is true, the projects the user
+// currently has access to (per cache.List) are recorded as already known,
+// so a later notification that the user still has access to one of them
+// produces no ADDED event; only losing access to it is reported (as DELETED).
+// recorder is optional; if nil, no events are emitted for operational
+// conditions like a full notification channel.
+//
+// allowWatchBookmarks mirrors the AllowWatchBookmarks field of the
+// ListOptions of the Watch call this watcher was created for. Bookmarks are
+// sent only when it is true and bookmarkInterval is non-zero; a zero
+// resyncPeriod disables periodic resync.
+func NewUserProjectWatcher(user user.Info, groups sets.String, projectCache *projectcache.ProjectCache, cache WatchableCache, includeAllExistingProjects bool, predicate storage.SelectionPredicate, recorder record.EventRecorder, allowWatchBookmarks bool, bookmarkInterval, resyncPeriod time.Duration) *userProjectWatcher {
+	w := &userProjectWatcher{
+		user:   user,
+		groups: groups,
+
+		predicate: predicate,
+
+		projectCache: projectCache,
+		cache:        cache,
+		recorder:     recorder,
+
+		knownProjects: sets.String{},
+
+		allowWatchBookmarks: allowWatchBookmarks,
+		bookmarkInterval:    bookmarkInterval,
+		resyncPeriod:        resyncPeriod,
+
+		cacheIncoming: make(chan watch.Event, notificationBufferSize),
+		cacheError:    make(chan error, 1),
+
+		outgoing: make(chan watch.Event),
+
+		stopChannel: make(chan struct{}),
+	}
+	w.emit = w.defaultEmit
+
+	if includeAllExistingProjects {
+		w.addExistingProjects()
+	}
+
+	return w
+}
+
+func (w *userProjectWatcher) addExistingProjects() {
+	namespaces, err := w.cache.List(w.user, labels.Everything())
+	if err != nil {
+		klog.Errorf("userProjectWatcher: error listing existing namespaces for %s: %v", w.user.GetName(), err)
+		return
+	}
+
+	w.knownProjectsLock.Lock()
+	defer w.knownProjectsLock.Unlock()
+	for i := range namespaces.Items {
+		w.knownProjects.Insert(namespaces.Items[i].Name)
+	}
+}
+
+// GroupMembershipChanged is called by the cache with the full, current set
+// of users and groups that have access to namespaceName. It is called
+// serially while the cache holds its lock, so it must never block on, or
+// call back into, the cache.
+func (w *userProjectWatcher) GroupMembershipChanged(namespaceName string, users, groups sets.String) {
+	project := newProjectFromNamespaceName(namespaceName)
+
+	matches, err := w.predicate.Matches(project)
+	if err != nil {
+		klog.Errorf("userProjectWatcher: error evaluating predicate for %s: %v", namespaceName, err)
+		return
+	}
+	if !matches {
+		return
+	}
+
+	hasAccess := users.Has(w.user.GetName()) || groups.HasAny(w.user.GetGroups()...) || hasAnyGroup(w.groups, groups)
+
+	// knownProjectsLock is held across both the knownProjects update and the
+	// resulting emit so that this event can never be interleaved with a
+	// concurrent resync's diff-and-emit for the same namespace: without that,
+	// the two could race and deliver their events out of order.
+	w.knownProjectsLock.Lock()
+	defer w.knownProjectsLock.Unlock()
+
+	_, known := w.knownProjects[namespaceName]
+	if hasAccess == known {
+		return
+	}
+	if hasAccess {
+		w.knownProjects.Insert(namespaceName)
+	} else {
+		w.knownProjects.Delete(namespaceName)
+	}
+
+	eventType := watch.Added
+	if !hasAccess {
+		eventType = watch.Deleted
+	}
+
+	project.ResourceVersion = w.nextResourceVersion()
+	w.emit(watch.Event{Type: eventType, Object: project})
+}
+
+// nextResourceVersion advances and returns a synthetic resourceVersion used
+// to order the Project events this watcher emits; it has no relation to the
+// backing namespace's actual resourceVersion.
+func (w *userProjectWatcher) nextResourceVersion() string {
+	w.rvLock.Lock()
+	defer w.rvLock.Unlock()
+	w.lastResourceVersion++
+	return strconv.FormatUint(w.lastResourceVersion, 10)
+}
+
+// currentResourceVersion returns the most recently assigned resourceVersion
+// without advancing it, for use on bookmark events.
+func (w *userProjectWatcher) currentResourceVersion() string {
+	w.rvLock.Lock()
+	defer w.rvLock.Unlock()
+	return strconv.FormatUint(w.lastResourceVersion, 10)
+}
+
+// newBookmarkEvent returns a watch.Bookmark event carrying the latest
+// resourceVersion this watcher has observed.
+func (w *userProjectWatcher) newBookmarkEvent() watch.Event {
+	return watch.Event{
+		Type:   watch.Bookmark,
+		Object: &projectapi.Project{ObjectMeta: metav1.ObjectMeta{ResourceVersion: w.currentResourceVersion()}},
+	}
+}
+
+// resync re-lists the namespaces this user currently has access to and
+// reconciles the result against knownProjects, emitting synthetic
+// ADDED/DELETED events for any namespace whose membership changed without
+// this watcher observing the underlying notification (for example, while it
+// was blocked delivering an earlier event).
+func (w *userProjectWatcher) resync() {
+	namespaces, err := w.cache.List(w.user, labels.Everything())
+	if err != nil {
+		klog.Errorf("userProjectWatcher: error resyncing namespaces for %s: %v", w.user.GetName(), err)
+		return
+	}
+
+	current := sets.String{}
+	for i := range namespaces.Items {
+		name := namespaces.Items[i].Name
+		matches, err := w.predicate.Matches(newProjectFromNamespaceName(name))
+		if err != nil {
+			klog.Errorf("userProjectWatcher: error evaluating predicate for %s: %v", name, err)
+			continue
+		}
+		if matches {
+			current.Insert(name)
+		}
+	}
+
+	// knownProjectsLock is held across the diff, the knownProjects swap, and
+	// the resulting emits so that this resync can never be interleaved with
+	// a concurrent GroupMembershipChanged call for the same namespace (same
+	// race this method exists to catch in the first place).
+	w.knownProjectsLock.Lock()
+	defer w.knownProjectsLock.Unlock()
+
+	added := current.Difference(w.knownProjects)
+	removed := w.knownProjects.Difference(current)
+	w.knownProjects = current
+
+	for name := range added {
+		project := newProjectFromNamespaceName(name)
+		project.ResourceVersion = w.nextResourceVersion()
+		w.emit(watch.Event{Type: watch.Added, Object: project})
+	}
+	for name := range removed {
+		project := newProjectFromNamespaceName(name)
+		project.ResourceVersion = w.nextResourceVersion()
+		w.emit(watch.Event{Type: watch.Deleted, Object: project})
+	}
+}
+
+// defaultEmit pushes event onto cacheIncoming without blocking. A watcher
+// that cannot keep up with its notifications is forcibly removed from the
+// cache rather than allowed to block it (or every other watcher) forever.
+func (w *userProjectWatcher) defaultEmit(event watch.Event) {
+	select {
+	case w.cacheIncoming <- event:
+	default:
+		w.removeOnce.Do(func() {
+			tooSlowErr := fmt.Errorf("watch channel full, removing slow watcher for user %q", w.user.GetName())
+			klog.V(2).Infof("userProjectWatcher: %v", tooSlowErr)
+
+			w.cache.RemoveWatcher(w)
+			w.recordWatchChannelFull()
+
+			select {
+			case w.cacheError <- tooSlowErr:
+			default:
+			}
+		})
+	}
+}
+
+// recordWatchChannelFull records a Warning event against a synthetic object
+// identifying the user, so operators can alarm on watch-buffer overruns
+// instead of only seeing a channel error on the affected watch. It is a
+// no-op if no recorder was configured.
+func (w *userProjectWatcher) recordWatchChannelFull() {
+	if w.recorder == nil {
+		return
+	}
+
+	involved := &corev1.ObjectReference{
+		Kind: "User",
+		Name: w.user.GetName(),
+	}
+	w.recorder.Eventf(involved, corev1.EventTypeWarning, "WatchChannelFull",
+		"project watch channel is full, removing watcher for user %q", w.user.GetName())
+}
+
+// Watch starts forwarding cache notifications (and, if this watcher was
+// forcibly removed for being too slow, a single error) to ResultChan(), and
+// drives periodic bookmarks and resync if configured. It returns once the
+// watcher is stopped or its notification source closes.
+func (w *userProjectWatcher) Watch() {
+	defer close(w.outgoing)
+
+	// A nil ticker channel blocks forever in a select, which is exactly how
+	// we disable bookmarks/resync without special-casing the loop below.
+	var bookmarkTick <-chan time.Time
+	if w.allowWatchBookmarks && w.bookmarkInterval > 0 {
+		ticker := time.NewTicker(w.bookmarkInterval)
+		defer ticker.Stop()
+		bookmarkTick = ticker.C
+	}
+
+	var resyncTick <-chan time.Time
+	if w.resyncPeriod > 0 {
+		ticker := time.NewTicker(w.resyncPeriod)
+		defer ticker.Stop()
+		resyncTick = ticker.C
+	}
+
+	for {
+		select {
+		case err := <-w.cacheError:
+			w.outgoing <- watch.Event{
+				Type: watch.Error,
+				Object: &metav1.Status{
+					Status:  metav1.StatusFailure,
+					Message: err.Error(),
+					Reason:  metav1.StatusReasonInternalError,
+				},
+			}
+			return
+
+		case event, ok := <-w.cacheIncoming:
+			if !ok {
+				return
+			}
+			w.outgoing <- event
+
+		case <-bookmarkTick:
+			w.outgoing <- w.newBookmarkEvent()
+
+		case <-resyncTick:
+			w.resync()
+
+		case <-w.stopChannel:
+			return
+		}
+	}
+}
+
+func (w *userProjectWatcher) ResultChan() <-chan watch.Event {
+	return w.outgoing
+}
+
+func (w *userProjectWatcher) Stop() {
+	w.stopOnce.Do(func() { close(w.stopChannel) })
+}
+
+func newProjectFromNamespaceName(name string) *projectapi.Project {
+	return &projectapi.Project{ObjectMeta: metav1.ObjectMeta{Name: name}}
+}
+
+func hasAnyGroup(have, want sets.String) bool {
+	for g := range want {
+		if have.Has(g) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -16,6 +18,7 @@ import (
 	"k8s.io/apiserver/pkg/storage"
 	informersv1 "k8s.io/client-go/informers"
 	fakev1 "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/kubernetes/pkg/controller"
 
 	projectapi "github.com/openshift/openshift-apiserver/pkg/project/apis/project"
@@ -24,6 +27,15 @@ import (
 )
 
 func newTestWatcher(username string, groups []string, predicate storage.SelectionPredicate, namespaces ...*corev1.Namespace) (*userProjectWatcher, *fakeAuthCache, chan struct{}) {
+	watcher, _, fakeAuthCache, stopCh := newTestWatcherWithRecorder(username, groups, predicate, namespaces...)
+	return watcher, fakeAuthCache, stopCh
+}
+
+func newTestWatcherWithRecorder(username string, groups []string, predicate storage.SelectionPredicate, namespaces ...*corev1.Namespace) (*userProjectWatcher, *record.FakeRecorder, *fakeAuthCache, chan struct{}) {
+	return newTestWatcherWithOptions(username, groups, predicate, false, 0, 0, namespaces...)
+}
+
+func newTestWatcherWithOptions(username string, groups []string, predicate storage.SelectionPredicate, allowWatchBookmarks bool, bookmarkInterval, resyncPeriod time.Duration, namespaces ...*corev1.Namespace) (*userProjectWatcher, *record.FakeRecorder, *fakeAuthCache, chan struct{}) {
 	objects := []runtime.Object{}
 	for i := range namespaces {
 		objects = append(objects, namespaces[i])
@@ -37,11 +49,13 @@ func newTestWatcher(username string, groups []string, predicate storage.Selectio
 		"",
 	)
 	fakeAuthCache := &fakeAuthCache{}
+	fakeRecorder := record.NewFakeRecorder(10)
 
 	stopCh := make(chan struct{})
 	go projectCache.Run(stopCh)
 
-	return NewUserProjectWatcher(&user.DefaultInfo{Name: username, Groups: groups}, sets.NewString("*"), projectCache, fakeAuthCache, false, predicate), fakeAuthCache, stopCh
+	watcher := NewUserProjectWatcher(&user.DefaultInfo{Name: username, Groups: groups}, sets.NewString("*"), projectCache, fakeAuthCache, false, predicate, fakeRecorder, allowWatchBookmarks, bookmarkInterval, resyncPeriod)
+	return watcher, fakeRecorder, fakeAuthCache, stopCh
 }
 
 type fakeAuthCache struct {
@@ -259,6 +273,197 @@ func TestAddModifyDeleteEventsByGroup(t *testing.T) {
 	}
 }
 
+func TestFullIncomingEmitsWatchChannelFullEvent(t *testing.T) {
+	watcher, fakeRecorder, _, stopCh := newTestWatcherWithRecorder("bob", nil, matchAllPredicate(), newNamespaces("ns-01")...)
+	defer close(stopCh)
+	watcher.cacheIncoming = make(chan watch.Event)
+
+	go watcher.Watch()
+	watcher.cacheIncoming <- watch.Event{Type: watch.Added}
+
+	// forces the self-removal path exercised by TestFullIncoming
+	watcher.GroupMembershipChanged("ns-01", sets.NewString("bob"), sets.String{})
+
+	select {
+	case msg := <-fakeRecorder.Events:
+		if !strings.Contains(msg, "WatchChannelFull") {
+			t.Errorf("expected a WatchChannelFull event, got %q", msg)
+		}
+		if !strings.Contains(msg, "Warning") {
+			t.Errorf("expected a Warning event, got %q", msg)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("timeout waiting for WatchChannelFull event")
+	}
+
+	select {
+	case msg := <-fakeRecorder.Events:
+		t.Fatalf("expected exactly one event, got an extra one: %q", msg)
+	default:
+	}
+}
+
+func TestWatchBookmarksArriveOnSchedule(t *testing.T) {
+	watcher, _, _, stopCh := newTestWatcherWithOptions("bob", nil, matchAllPredicate(), true, 50*time.Millisecond, 0, newNamespaces("ns-01")...)
+	defer close(stopCh)
+	go watcher.Watch()
+
+	select {
+	case event := <-watcher.ResultChan():
+		if event.Type != watch.Bookmark {
+			t.Fatalf("expected a Bookmark event, got %v", event)
+		}
+		if event.Object.(*projectapi.Project).ResourceVersion == "" {
+			t.Errorf("expected the bookmark to carry a non-empty resourceVersion")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("timeout waiting for bookmark event")
+	}
+}
+
+func TestWatchBookmarksSuppressedWhenNotRequested(t *testing.T) {
+	watcher, _, _, stopCh := newTestWatcherWithOptions("bob", nil, matchAllPredicate(), false, 50*time.Millisecond, 0, newNamespaces("ns-01")...)
+	defer close(stopCh)
+	go watcher.Watch()
+
+	select {
+	case event := <-watcher.ResultChan():
+		t.Fatalf("unexpected event, bookmarks were not requested: %v", event)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestWatchResyncReconcilesMissedMembershipChange(t *testing.T) {
+	watcher, _, fakeAuthCache, stopCh := newTestWatcherWithOptions("bob", nil, matchAllPredicate(), false, 0, 50*time.Millisecond, newNamespaces("ns-01")...)
+	defer close(stopCh)
+	go watcher.Watch()
+
+	watcher.GroupMembershipChanged("ns-01", sets.NewString("bob"), sets.String{})
+	select {
+	case event := <-watcher.ResultChan():
+		if event.Type != watch.Added {
+			t.Fatalf("expected added, got %v", event)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("timeout")
+	}
+
+	// simulate the membership change happening while this watcher was
+	// blocked and never observed the notification: the cache's view of the
+	// world moves on without a matching GroupMembershipChanged call.
+	fakeAuthCache.namespaces = newNamespaces("ns-02")
+
+	seen := map[string]watch.EventType{}
+	for len(seen) < 2 {
+		select {
+		case event := <-watcher.ResultChan():
+			seen[event.Object.(*projectapi.Project).Name] = event.Type
+		case <-time.After(3 * time.Second):
+			t.Fatalf("timeout waiting for resync to reconcile, saw %v", seen)
+		}
+	}
+
+	if seen["ns-01"] != watch.Deleted {
+		t.Errorf("expected Deleted ns-01 from resync, got %v", seen["ns-01"])
+	}
+	if seen["ns-02"] != watch.Added {
+		t.Errorf("expected Added ns-02 from resync, got %v", seen["ns-02"])
+	}
+}
+
+func TestResyncOnlyRecordsOneWatchChannelFullEvent(t *testing.T) {
+	watcher, fakeRecorder, fakeAuthCache, stopCh := newTestWatcherWithOptions("bob", nil, matchAllPredicate(), false, 0, time.Hour, newNamespaces()...)
+	defer close(stopCh)
+	// unbuffered with no reader: every emit during resync finds it full
+	watcher.cacheIncoming = make(chan watch.Event)
+
+	// several namespaces appear at once, so resync's reconciliation loop
+	// calls emit more than once while the watcher is already "removed"
+	fakeAuthCache.namespaces = newNamespaces("ns-01", "ns-02", "ns-03")
+
+	watcher.resync()
+
+	if len(fakeAuthCache.removed) != 1 {
+		t.Fatalf("expected exactly one RemoveWatcher call, got %d", len(fakeAuthCache.removed))
+	}
+
+	select {
+	case msg := <-fakeRecorder.Events:
+		if !strings.Contains(msg, "WatchChannelFull") {
+			t.Errorf("expected a WatchChannelFull event, got %q", msg)
+		}
+	default:
+		t.Fatalf("expected a WatchChannelFull event")
+	}
+	select {
+	case msg := <-fakeRecorder.Events:
+		t.Fatalf("expected exactly one WatchChannelFull event, got an extra one: %q", msg)
+	default:
+	}
+}
+
+func TestResyncAndGroupMembershipChangedDoNotInterleaveEmits(t *testing.T) {
+	// resync discovers ns-01 as newly accessible (e.g. the user was just
+	// granted access and the cache's list already reflects it) while this
+	// watcher's knownProjects doesn't know about it yet.
+	watcher, _, fakeAuthCache, stopCh := newTestWatcherWithOptions("bob", nil, matchAllPredicate(), false, 0, 0, newNamespaces("ns-01")...)
+	defer close(stopCh)
+	fakeAuthCache.namespaces = newNamespaces("ns-01")
+
+	var mu sync.Mutex
+	var order []string
+	groupChangeStarted := make(chan struct{})
+	first := true
+	watcher.emit = func(event watch.Event) {
+		mu.Lock()
+		order = append(order, event.Object.(*projectapi.Project).Name+":"+string(event.Type))
+		mu.Unlock()
+
+		// On resync's emit of the stale Added, kick off a concurrent
+		// GroupMembershipChanged revoking access to the same namespace, and
+		// give it time to reach (and block on) knownProjectsLock before
+		// resync's critical section ends. If resync's lock didn't span the
+		// whole diff+emit sequence, GroupMembershipChanged's Deleted could
+		// race ahead of resync's still-pending Added.
+		if first {
+			first = false
+			go func() {
+				close(groupChangeStarted)
+				watcher.GroupMembershipChanged("ns-01", sets.String{}, sets.String{})
+			}()
+			<-groupChangeStarted
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	watcher.resync()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		mu.Lock()
+		n := len(order)
+		mu.Unlock()
+		if n >= 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for GroupMembershipChanged's event, got %v", order)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(order) != 2 {
+		t.Fatalf("expected exactly 2 emitted events, got %v", order)
+	}
+	// resync's Added must be fully emitted before GroupMembershipChanged's
+	// Deleted is ever observed -- never interleaved, and never reordered.
+	if order[0] != "ns-01:Added" || order[1] != "ns-01:Deleted" {
+		t.Errorf("expected [ns-01:Added ns-01:Deleted] in order, got %v", order)
+	}
+}
+
 func newNamespaces(names ...string) []*corev1.Namespace {
 	ret := []*corev1.Namespace{}
 	for _, name := range names {
@@ -1,8 +1,13 @@
 package simple
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base32"
 	"errors"
 	"fmt"
+	"strings"
+	"text/template"
 
 	"code.google.com/p/go-uuid/uuid"
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/util"
@@ -15,14 +20,40 @@ import (
 // Would be better if we could use "v3.openshift.app", someone bought that!
 const defaultDNSSuffix = "v3.openshift.com"
 
+// DNS limits enforced on generated hostnames, see RFC 1035.
+const (
+	maxLabelLength = 63
+	maxFQDNLength  = 253
+	// hashSuffixLength is the length of the deterministic suffix appended to
+	// a truncated label, so that two labels which collide on their first
+	// maxLabelLength characters still generate distinct hostnames.
+	hashSuffixLength = 6
+)
+
 // SimpleAllocationPlugin implements the route.AllocationPlugin interface
 // to provide a simple unsharded (or single sharded) allocation plugin.
 type SimpleAllocationPlugin struct {
 	DNSSuffix string
+
+	hostnameTemplate *template.Template
 }
 
-// Creates a new SimpleAllocationPlugin.
-func NewSimpleAllocationPlugin(suffix string) (*SimpleAllocationPlugin, error) {
+// hostnameParams is the data made available to the hostname template passed
+// to NewSimpleAllocationPlugin.
+type hostnameParams struct {
+	Name        string
+	Namespace   string
+	ServiceName string
+	Suffix      string
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+// Creates a new SimpleAllocationPlugin. hostnameTemplate is an optional
+// text/template string (e.g. "{{.Name}}-{{.Namespace}}.{{.Suffix}}") used to
+// render generated hostnames; if empty, GenerateHostname falls back to its
+// original "name-namespace.suffix" scheme.
+func NewSimpleAllocationPlugin(suffix, hostnameTemplate string) (*SimpleAllocationPlugin, error) {
 	if len(suffix) == 0 {
 		suffix = defaultDNSSuffix
 	}
@@ -36,7 +67,28 @@ func NewSimpleAllocationPlugin(suffix string) (*SimpleAllocationPlugin, error) {
 		return nil, errors.New(errmsg)
 	}
 
-	return &SimpleAllocationPlugin{DNSSuffix: suffix}, nil
+	plugin := &SimpleAllocationPlugin{DNSSuffix: suffix}
+
+	if len(hostnameTemplate) > 0 {
+		tmpl, err := template.New("hostname").Parse(hostnameTemplate)
+		if err != nil {
+			errmsg := fmt.Sprintf("invalid hostname template: %v", err)
+			glog.Errorf("NewSimpleAllocationPlugin: %s", errmsg)
+			return nil, errors.New(errmsg)
+		}
+
+		// Dry-run the template so a template referencing a bad field fails
+		// here, at construction time, rather than on the first route created.
+		if _, err := renderHostnameTemplate(tmpl, hostnameParams{Name: "dry-run", Namespace: "dry-run", ServiceName: "dry-run", Suffix: suffix}); err != nil {
+			errmsg := fmt.Sprintf("invalid hostname template: %v", err)
+			glog.Errorf("NewSimpleAllocationPlugin: %s", errmsg)
+			return nil, errors.New(errmsg)
+		}
+
+		plugin.hostnameTemplate = tmpl
+	}
+
+	return plugin, nil
 }
 
 // Allocate a router shard for the given route. This plugin always returns
@@ -50,7 +102,11 @@ func (p *SimpleAllocationPlugin) Allocate(route *routeapi.Route) (*routeapi.Rout
 }
 
 // Generate a host name for a route - using the service name,
-// namespace (if provided) and the router shard dns suffix.
+// namespace (if provided) and the router shard dns suffix, or the
+// configured hostname template if one was supplied. The result is truncated
+// to satisfy DNS label and FQDN length limits, except when the shard's DNS
+// suffix alone leaves no room, in which case it stays overlong (see
+// truncateHostname).
 func (p *SimpleAllocationPlugin) GenerateHostname(route *routeapi.Route, shard *routeapi.RouterShard) string {
 
 	name := route.ServiceName
@@ -60,13 +116,155 @@ func (p *SimpleAllocationPlugin) GenerateHostname(route *routeapi.Route, shard *
 	}
 
 	s := ""
-	if len(route.Namespace) <= 0 {
-		s = fmt.Sprintf("%s.%s", name, shard.DNSSuffix)
+	if p.hostnameTemplate != nil {
+		params := hostnameParams{
+			Name:        route.Name,
+			Namespace:   route.Namespace,
+			ServiceName: name,
+			Suffix:      shard.DNSSuffix,
+			Labels:      route.Labels,
+			Annotations: route.Annotations,
+		}
+		rendered, err := renderHostnameTemplate(p.hostnameTemplate, params)
+		if err != nil {
+			// The template was dry-run at construction time, so this should
+			// never happen; fall back to the default naming scheme rather
+			// than producing an unusable hostname.
+			glog.Errorf("SimpleAllocationPlugin: error rendering hostname template, falling back to default naming: %v", err)
+			s = p.defaultHostname(name, route.Namespace, shard)
+		} else {
+			s = rendered
+		}
 	} else {
-		s = fmt.Sprintf("%s-%s.%s", name, route.Namespace, shard.DNSSuffix)
+		s = p.defaultHostname(name, route.Namespace, shard)
 	}
 
+	s = truncateHostname(s, shard.DNSSuffix)
+
 	glog.V(4).Infof("SimpleAllocationPlugin: Generated hostname=%s for Route: %s", s, route.ServiceName)
 
 	return s
 }
+
+func (p *SimpleAllocationPlugin) defaultHostname(name, namespace string, shard *routeapi.RouterShard) string {
+	if len(namespace) <= 0 {
+		return fmt.Sprintf("%s.%s", name, shard.DNSSuffix)
+	}
+	return fmt.Sprintf("%s-%s.%s", name, namespace, shard.DNSSuffix)
+}
+
+func renderHostnameTemplate(tmpl *template.Template, params hostnameParams) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, params); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// minTruncatedLabelLength is the shortest truncateLabel can ever shrink a
+// label to: a single "-" separator plus the hash suffix, with nothing of
+// the original label kept.
+const minTruncatedLabelLength = hashSuffixLength + 1
+
+// truncateHostname enforces DNS label (<=63 chars) and FQDN (<=253 chars)
+// length limits on hostname, which is expected to end in "."+suffix (the
+// shard's configured DNS suffix). Only the labels derived from the route and
+// its template (everything before suffix) are ever truncated or shed;
+// suffix itself is never touched, since shrinking or dropping part of it
+// would silently move the route out from under the administrator-configured
+// domain/zone. If convergence would require touching suffix, the best-effort
+// (still overlong) hostname is returned and an error is logged, since
+// GenerateHostname has no error return to surface this to its caller.
+func truncateHostname(hostname, suffix string) string {
+	hostPart := hostname
+	if len(suffix) > 0 && strings.HasSuffix(hostname, "."+suffix) {
+		hostPart = hostname[:len(hostname)-len(suffix)-1]
+	} else {
+		// hostname isn't actually rooted at suffix (e.g. a template that
+		// doesn't reference {{.Suffix}}); nothing to protect, so fall back
+		// to truncating the whole thing.
+		suffix = ""
+	}
+
+	labels := strings.Split(hostPart, ".")
+
+	for i, label := range labels {
+		if len(label) > maxLabelLength {
+			labels[i] = truncateLabel(label, maxLabelLength)
+		}
+	}
+
+	budget := maxFQDNLength
+	if len(suffix) > 0 {
+		budget -= len(suffix) + 1 // "." separator
+	}
+
+	for len(labels) > 0 {
+		joined := strings.Join(labels, ".")
+		if len(joined) <= budget {
+			return joinHostname(joined, suffix)
+		}
+
+		overflow := len(joined) - budget
+		target := len(labels[0]) - overflow
+		if target < minTruncatedLabelLength {
+			target = minTruncatedLabelLength
+		}
+
+		if target < len(labels[0]) {
+			labels[0] = truncateLabel(labels[0], target)
+			continue
+		}
+
+		// labels[0] is already at its truncation floor and the FQDN is
+		// still too long; shed the least-specific (right-most) label.
+		if len(labels) == 1 {
+			break
+		}
+		labels = labels[:len(labels)-1]
+	}
+
+	if len(suffix) > 0 {
+		glog.Errorf("truncateHostname: cannot truncate hostname %q to fit within %d characters without discarding part of the configured DNS suffix %q; returning a best-effort hostname that is still too long", hostname, maxFQDNLength, suffix)
+	}
+	return joinHostname(strings.Join(labels, "."), suffix)
+}
+
+// joinHostname re-attaches suffix (if any) to hostPart.
+func joinHostname(hostPart, suffix string) string {
+	if len(suffix) == 0 {
+		return hostPart
+	}
+	if len(hostPart) == 0 {
+		return suffix
+	}
+	return hostPart + "." + suffix
+}
+
+// truncateLabel shortens label to at most maxLen characters, replacing the
+// trimmed portion with a deterministic hash suffix derived from the
+// pre-truncation label so that uniqueness is preserved across regenerations.
+func truncateLabel(label string, maxLen int) string {
+	suffix := labelHashSuffix(label)
+
+	keep := maxLen - len(suffix) - 1 // "-" separator
+	if keep < 0 {
+		keep = 0
+	}
+	if keep > len(label) {
+		keep = len(label)
+	}
+
+	return fmt.Sprintf("%s-%s", label[:keep], suffix)
+}
+
+// labelHashSuffix returns a short, deterministic, DNS-label-safe suffix
+// derived from label's SHA-256 hash.
+func labelHashSuffix(label string) string {
+	sum := sha256.Sum256([]byte(label))
+	encoded := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(sum[:]))
+	if len(encoded) > hashSuffixLength {
+		encoded = encoded[:hashSuffixLength]
+	}
+	return encoded
+}
@@ -0,0 +1,114 @@
+package simple
+
+import (
+	"strings"
+	"testing"
+
+	kapi "github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+
+	routeapi "github.com/openshift/origin/pkg/route/api"
+)
+
+func TestNewSimpleAllocationPluginInvalidTemplate(t *testing.T) {
+	if _, err := NewSimpleAllocationPlugin("v3.openshift.com", "{{.Name"); err == nil {
+		t.Fatalf("expected a template parse error, got none")
+	}
+
+	if _, err := NewSimpleAllocationPlugin("v3.openshift.com", "{{.NotAField}}"); err == nil {
+		t.Fatalf("expected a dry-run render error for an unknown field, got none")
+	}
+}
+
+func TestGenerateHostnameDefaultBehaviorWithoutTemplate(t *testing.T) {
+	plugin, err := NewSimpleAllocationPlugin("v3.openshift.com", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	route := &routeapi.Route{ObjectMeta: kapi.ObjectMeta{Namespace: "ns-01"}, ServiceName: "svc"}
+	shard := &routeapi.RouterShard{ShardName: "global", DNSSuffix: plugin.DNSSuffix}
+
+	if got, want := plugin.GenerateHostname(route, shard), "svc-ns-01.v3.openshift.com"; got != want {
+		t.Errorf("got hostname %q, want %q", got, want)
+	}
+}
+
+func TestGenerateHostnameWithTemplate(t *testing.T) {
+	plugin, err := NewSimpleAllocationPlugin("v3.openshift.com", "{{.ServiceName}}.{{.Namespace}}.{{.Suffix}}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	route := &routeapi.Route{ObjectMeta: kapi.ObjectMeta{Namespace: "ns-01"}, ServiceName: "svc"}
+	shard := &routeapi.RouterShard{ShardName: "global", DNSSuffix: plugin.DNSSuffix}
+
+	if got, want := plugin.GenerateHostname(route, shard), "svc.ns-01.v3.openshift.com"; got != want {
+		t.Errorf("got hostname %q, want %q", got, want)
+	}
+}
+
+func TestGenerateHostnameLabelTruncation(t *testing.T) {
+	longName := strings.Repeat("a", 100)
+	plugin, err := NewSimpleAllocationPlugin("v3.openshift.com", "{{.ServiceName}}.{{.Suffix}}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	route := &routeapi.Route{ObjectMeta: kapi.ObjectMeta{Namespace: "ns-01"}, ServiceName: longName}
+	shard := &routeapi.RouterShard{ShardName: "global", DNSSuffix: plugin.DNSSuffix}
+
+	got := plugin.GenerateHostname(route, shard)
+	label := strings.SplitN(got, ".", 2)[0]
+	if len(label) > maxLabelLength {
+		t.Fatalf("label %q (%d chars) exceeds %d chars", label, len(label), maxLabelLength)
+	}
+	if !strings.HasSuffix(label, "-"+labelHashSuffix(longName)) {
+		t.Errorf("expected truncated label %q to end with the deterministic hash suffix of %q", label, longName)
+	}
+
+	// regenerating from the same input truncates identically
+	if got2 := plugin.GenerateHostname(route, shard); got2 != got {
+		t.Errorf("truncation is not deterministic: %q != %q", got, got2)
+	}
+}
+
+func TestGenerateHostnameWholeHostnameTruncation(t *testing.T) {
+	// The template renders many labels, each well within maxLabelLength, but
+	// there are enough of them that the whole FQDN exceeds maxFQDNLength; the
+	// configured DNSSuffix itself is short and must survive untouched.
+	suffix := "v3.openshift.com"
+	hostPart := strings.TrimSuffix(strings.Repeat("abcdefghij.", 23), ".")
+	plugin, err := NewSimpleAllocationPlugin(suffix, "{{.ServiceName}}.{{.Suffix}}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	route := &routeapi.Route{ObjectMeta: kapi.ObjectMeta{Namespace: "ns-01"}, ServiceName: hostPart}
+	shard := &routeapi.RouterShard{ShardName: "global", DNSSuffix: plugin.DNSSuffix}
+
+	got := plugin.GenerateHostname(route, shard)
+	if len(got) > maxFQDNLength {
+		t.Fatalf("hostname %q (%d chars) exceeds %d chars", got, len(got), maxFQDNLength)
+	}
+	for _, label := range strings.Split(got, ".") {
+		if len(label) > maxLabelLength {
+			t.Fatalf("label %q (%d chars) exceeds %d chars", label, len(label), maxLabelLength)
+		}
+	}
+	if !strings.HasSuffix(got, "."+suffix) {
+		t.Fatalf("hostname %q does not preserve the configured DNS suffix %q", got, suffix)
+	}
+}
+
+func TestTruncateHostnameNeverTruncatesSuffix(t *testing.T) {
+	// Even a pathologically long suffix must be returned unmodified: the
+	// function logs and returns a best-effort (overlong) hostname rather than
+	// shedding part of the administrator-configured suffix.
+	suffix := strings.TrimSuffix(strings.Repeat("abcdefghij.", 23), ".")
+	hostname := "svc-ns." + suffix
+
+	got := truncateHostname(hostname, suffix)
+	if !strings.HasSuffix(got, "."+suffix) {
+		t.Fatalf("truncateHostname(%q, %q) = %q, does not preserve suffix", hostname, suffix, got)
+	}
+}
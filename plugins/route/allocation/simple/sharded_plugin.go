@@ -0,0 +1,162 @@
+package simple
+
+import (
+	"fmt"
+
+	kapi "github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/labels"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/util"
+	"github.com/golang/glog"
+
+	routeapi "github.com/openshift/origin/pkg/route/api"
+)
+
+// ShardConfig describes a single router shard and the selectors used to
+// decide whether a given Route is allocated to it.
+type ShardConfig struct {
+	// ShardName uniquely identifies this shard.
+	ShardName string
+	// DNSSuffix is the DNS suffix routes allocated to this shard are hosted under.
+	DNSSuffix string
+
+	// NamespaceSelector, when non-empty, is matched against the labels and
+	// annotations of the Route's namespace.
+	NamespaceSelector string
+	// RouteSelector, when non-empty, is matched against the labels and
+	// annotations of the Route itself.
+	RouteSelector string
+
+	namespaceSelector labels.Selector
+	routeSelector     labels.Selector
+}
+
+// NamespaceLister looks up a namespace by name. It lets ShardedAllocationPlugin
+// evaluate a shard's NamespaceSelector without depending on a particular
+// namespace store implementation.
+type NamespaceLister interface {
+	GetNamespace(name string) (*kapi.Namespace, error)
+}
+
+// ShardedAllocationPlugin implements the route.AllocationPlugin interface,
+// choosing among a list of configured router shards based on label/annotation
+// selectors evaluated against the Route and its namespace. Shards are tried
+// in order and the first match wins; if none match, a default shard is
+// returned. Modeled on the annotation-selector pattern used elsewhere to
+// scope managed nodes in controllers.
+type ShardedAllocationPlugin struct {
+	shards       []ShardConfig
+	namespaces   NamespaceLister
+	defaultShard routeapi.RouterShard
+}
+
+// NewShardedAllocationPlugin creates a new ShardedAllocationPlugin from the
+// given shard configuration. shards are evaluated in the order given; the
+// first shard whose selectors match wins. defaultShard is returned when no
+// configured shard matches. namespaces may be nil only if none of the
+// shards use a NamespaceSelector; a shard with a NamespaceSelector and no
+// namespaces lister is a construction error rather than a shard that
+// silently never matches.
+func NewShardedAllocationPlugin(defaultShard routeapi.RouterShard, shards []ShardConfig, namespaces NamespaceLister) (*ShardedAllocationPlugin, error) {
+	if !util.IsDNSSubdomain(defaultShard.DNSSuffix) {
+		return nil, fmt.Errorf("invalid DNS suffix for default shard %s: %s", defaultShard.ShardName, defaultShard.DNSSuffix)
+	}
+
+	parsed := make([]ShardConfig, 0, len(shards))
+	for _, shard := range shards {
+		if !util.IsDNSSubdomain(shard.DNSSuffix) {
+			return nil, fmt.Errorf("invalid DNS suffix for shard %s: %s", shard.ShardName, shard.DNSSuffix)
+		}
+
+		if len(shard.NamespaceSelector) > 0 {
+			if namespaces == nil {
+				return nil, fmt.Errorf("shard %s has a NamespaceSelector but no NamespaceLister was provided", shard.ShardName)
+			}
+
+			selector, err := labels.Parse(shard.NamespaceSelector)
+			if err != nil {
+				return nil, fmt.Errorf("invalid namespace selector for shard %s: %v", shard.ShardName, err)
+			}
+			shard.namespaceSelector = selector
+		}
+		if len(shard.RouteSelector) > 0 {
+			selector, err := labels.Parse(shard.RouteSelector)
+			if err != nil {
+				return nil, fmt.Errorf("invalid route selector for shard %s: %v", shard.ShardName, err)
+			}
+			shard.routeSelector = selector
+		}
+
+		parsed = append(parsed, shard)
+	}
+
+	glog.V(4).Infof("NewShardedAllocationPlugin: %d shard(s) configured, default=%s", len(parsed), defaultShard.ShardName)
+
+	return &ShardedAllocationPlugin{shards: parsed, namespaces: namespaces, defaultShard: defaultShard}, nil
+}
+
+// Allocate a router shard for the given route, evaluating configured shards
+// in order and returning the first whose selectors match. Falls back to the
+// default shard when no shard matches.
+func (p *ShardedAllocationPlugin) Allocate(route *routeapi.Route) (*routeapi.RouterShard, error) {
+	routeSet := mergedLabelSet(route.Labels, route.Annotations)
+
+	for i := range p.shards {
+		shard := &p.shards[i]
+
+		if shard.routeSelector != nil && !shard.routeSelector.Matches(routeSet) {
+			continue
+		}
+
+		if shard.namespaceSelector != nil {
+			matches, err := p.namespaceMatches(route.Namespace, shard.namespaceSelector)
+			if err != nil {
+				glog.Errorf("ShardedAllocationPlugin: error looking up namespace %s: %v", route.Namespace, err)
+				continue
+			}
+			if !matches {
+				continue
+			}
+		}
+
+		glog.V(4).Infof("ShardedAllocationPlugin: allocating shard %s to Route: %s", shard.ShardName, route.ServiceName)
+		return &routeapi.RouterShard{ShardName: shard.ShardName, DNSSuffix: shard.DNSSuffix}, nil
+	}
+
+	glog.V(4).Infof("ShardedAllocationPlugin: no shard matched, falling back to default shard %s for Route: %s", p.defaultShard.ShardName, route.ServiceName)
+	return &p.defaultShard, nil
+}
+
+func (p *ShardedAllocationPlugin) namespaceMatches(name string, selector labels.Selector) (bool, error) {
+	if p.namespaces == nil {
+		return false, nil
+	}
+
+	ns, err := p.namespaces.GetNamespace(name)
+	if err != nil {
+		return false, err
+	}
+	if ns == nil {
+		// Lister doesn't know about this namespace yet (not synced, or it
+		// was deleted); treat it as a non-match rather than a lookup error.
+		return false, nil
+	}
+
+	return selector.Matches(mergedLabelSet(ns.Labels, ns.Annotations)), nil
+}
+
+func mergedLabelSet(labelMap, annotationMap map[string]string) labels.Set {
+	set := make(labels.Set, len(labelMap)+len(annotationMap))
+	for k, v := range annotationMap {
+		set[k] = v
+	}
+	for k, v := range labelMap {
+		set[k] = v
+	}
+	return set
+}
+
+// GenerateHostname generates a host name for a route, using the same naming
+// scheme as SimpleAllocationPlugin applied to whichever shard was allocated.
+func (p *ShardedAllocationPlugin) GenerateHostname(route *routeapi.Route, shard *routeapi.RouterShard) string {
+	return (&SimpleAllocationPlugin{DNSSuffix: shard.DNSSuffix}).GenerateHostname(route, shard)
+}
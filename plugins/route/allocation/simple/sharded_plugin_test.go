@@ -0,0 +1,122 @@
+package simple
+
+import (
+	"testing"
+
+	kapi "github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+
+	routeapi "github.com/openshift/origin/pkg/route/api"
+)
+
+type fakeNamespaceLister map[string]*kapi.Namespace
+
+func (l fakeNamespaceLister) GetNamespace(name string) (*kapi.Namespace, error) {
+	return l[name], nil
+}
+
+func newNamespace(name string, labels map[string]string) *kapi.Namespace {
+	return &kapi.Namespace{
+		ObjectMeta: kapi.ObjectMeta{Name: name, Labels: labels},
+	}
+}
+
+func TestShardedAllocationPluginNoMatchFallsBackToDefault(t *testing.T) {
+	shards := []ShardConfig{
+		{ShardName: "east", DNSSuffix: "east.example.com", NamespaceSelector: "region=east"},
+	}
+	namespaces := fakeNamespaceLister{"ns-01": newNamespace("ns-01", map[string]string{"region": "west"})}
+
+	plugin, err := NewShardedAllocationPlugin(routeapi.RouterShard{ShardName: "global", DNSSuffix: "v3.openshift.com"}, shards, namespaces)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	route := &routeapi.Route{ObjectMeta: kapi.ObjectMeta{Namespace: "ns-01"}, ServiceName: "svc"}
+	shard, err := plugin.Allocate(route)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if shard.ShardName != "global" {
+		t.Errorf("expected fallback to default shard, got %s", shard.ShardName)
+	}
+}
+
+func TestShardedAllocationPluginFirstMatchWins(t *testing.T) {
+	shards := []ShardConfig{
+		{ShardName: "any", DNSSuffix: "any.example.com", RouteSelector: "tier=frontend"},
+		{ShardName: "east", DNSSuffix: "east.example.com", RouteSelector: "tier=frontend"},
+	}
+
+	plugin, err := NewShardedAllocationPlugin(routeapi.RouterShard{ShardName: "global", DNSSuffix: "v3.openshift.com"}, shards, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	route := &routeapi.Route{
+		ObjectMeta:  kapi.ObjectMeta{Namespace: "ns-01", Labels: map[string]string{"tier": "frontend"}},
+		ServiceName: "svc",
+	}
+	shard, err := plugin.Allocate(route)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if shard.ShardName != "any" {
+		t.Errorf("expected first matching shard %q, got %q", "any", shard.ShardName)
+	}
+}
+
+func TestShardedAllocationPluginSelectorParseError(t *testing.T) {
+	shards := []ShardConfig{
+		{ShardName: "east", DNSSuffix: "east.example.com", RouteSelector: "this is not a selector=="},
+	}
+
+	if _, err := NewShardedAllocationPlugin(routeapi.RouterShard{ShardName: "global", DNSSuffix: "v3.openshift.com"}, shards, nil); err == nil {
+		t.Fatalf("expected a selector parse error, got none")
+	}
+}
+
+func TestShardedAllocationPluginNamespaceMissingFromListerFallsBackToDefault(t *testing.T) {
+	shards := []ShardConfig{
+		{ShardName: "east", DNSSuffix: "east.example.com", NamespaceSelector: "region=east"},
+	}
+	// ns-01 is not in the lister at all (e.g. not yet synced, or deleted).
+	namespaces := fakeNamespaceLister{}
+
+	plugin, err := NewShardedAllocationPlugin(routeapi.RouterShard{ShardName: "global", DNSSuffix: "v3.openshift.com"}, shards, namespaces)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	route := &routeapi.Route{ObjectMeta: kapi.ObjectMeta{Namespace: "ns-01"}, ServiceName: "svc"}
+	shard, err := plugin.Allocate(route)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if shard.ShardName != "global" {
+		t.Errorf("expected fallback to default shard, got %s", shard.ShardName)
+	}
+}
+
+func TestShardedAllocationPluginRejectsNamespaceSelectorWithoutLister(t *testing.T) {
+	shards := []ShardConfig{
+		{ShardName: "east", DNSSuffix: "east.example.com", NamespaceSelector: "region=east"},
+	}
+
+	if _, err := NewShardedAllocationPlugin(routeapi.RouterShard{ShardName: "global", DNSSuffix: "v3.openshift.com"}, shards, nil); err == nil {
+		t.Fatalf("expected an error for a NamespaceSelector with no NamespaceLister, got none")
+	}
+}
+
+func TestShardedAllocationPluginRejectsInvalidDNSSuffix(t *testing.T) {
+	shards := []ShardConfig{
+		{ShardName: "east", DNSSuffix: "not a valid suffix!"},
+	}
+
+	if _, err := NewShardedAllocationPlugin(routeapi.RouterShard{ShardName: "global", DNSSuffix: "v3.openshift.com"}, shards, nil); err == nil {
+		t.Fatalf("expected an invalid DNS suffix error, got none")
+	}
+
+	if _, err := NewShardedAllocationPlugin(routeapi.RouterShard{ShardName: "global", DNSSuffix: "not a valid suffix!"}, nil, nil); err == nil {
+		t.Fatalf("expected an invalid DNS suffix error for the default shard, got none")
+	}
+}